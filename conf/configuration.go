@@ -0,0 +1,54 @@
+// Package conf holds Navidrome's server-wide configuration. This file only
+// declares the subset consumed by the cover art resize/encode pipeline in
+// core/artwork; the rest of the real configOptions struct lives alongside it.
+package conf
+
+// configOptions is the live server configuration, populated at startup from
+// config file/env/flags.
+type configOptions struct {
+	// CoverArtFormat is the encoder used for resized cover art when no per-request
+	// format was negotiated: one of "jpeg", "webp", "jxl", "avif".
+	CoverArtFormat string
+	// CoverArtMinQuality and CoverArtMaxQuality bound the lossy encode quality
+	// qualityBySize interpolates between based on requested thumbnail size.
+	CoverArtMinQuality int
+	CoverArtMaxQuality int
+
+	// CoverArtMaxBytes caps the encoded size of a resized thumbnail; 0 disables
+	// the cap and resizeImage is used as-is.
+	CoverArtMaxBytes int
+	// CoverArtQualityStep is how much quality drops per iteration while searching
+	// for an encode under CoverArtMaxBytes, before falling back to downscaling.
+	CoverArtQualityStep int
+
+	// ThumbnailPresets lists the fixed sizes requested sizes are snapped to (and
+	// that PregenerateThumbnails renders), instead of resizing/encoding arbitrary
+	// requested sizes on every request. Empty disables snapping entirely.
+	ThumbnailPresets []ThumbnailPreset
+	// DynamicThumbnails disables preset snapping even when ThumbnailPresets is set,
+	// always resizing to the exact requested size instead.
+	DynamicThumbnails bool
+
+	// CoverArtSquareMode selects how non-square source art is made square for a
+	// square=true request: "smart" (edge-energy crop), "center" (naive center
+	// crop), or "pad" (default; letterbox instead of cropping).
+	CoverArtSquareMode string
+}
+
+// ThumbnailPreset is one entry in the configured cover art thumbnail size ladder.
+type ThumbnailPreset struct {
+	Size   int
+	Square bool
+	// Format is the encoder PregenerateThumbnails requests for this preset; empty
+	// falls back to CoverArtFormat, same as an unrecognized value would.
+	Format string
+}
+
+// Server holds the active configuration.
+var Server = &configOptions{
+	CoverArtFormat:      "jpeg",
+	CoverArtMinQuality:  60,
+	CoverArtMaxQuality:  95,
+	CoverArtQualityStep: 5,
+	CoverArtSquareMode:  "pad",
+}
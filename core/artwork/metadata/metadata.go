@@ -0,0 +1,258 @@
+// Package metadata pulls the bits of a cover art file that have to survive a
+// resize/re-encode even though resizeImage's own decoder throws them away: an
+// embedded ICC color profile and the EXIF orientation tag. Both are read directly
+// out of the header bytes resizeImage already peeks, without a full re-parse of
+// the file.
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+)
+
+// Info holds the color/orientation metadata extracted from an image header.
+type Info struct {
+	// ICCProfile is the raw embedded color profile, if any.
+	ICCProfile []byte
+	// Orientation is the EXIF orientation value (1-8), or 0 if absent or out of range.
+	Orientation int
+}
+
+// Extract reads ICC profile and EXIF orientation metadata out of header, a prefix
+// of the source file, for the given decoded image format ("jpeg", "png", "heic").
+// It returns a zero Info if the format isn't recognized or carries no metadata.
+func Extract(header []byte, format string) Info {
+	switch format {
+	case "jpeg":
+		return extractJPEGStyle(header)
+	case "png":
+		return extractPNG(header)
+	case "heic":
+		return extractHEIC(header)
+	default:
+		return Info{}
+	}
+}
+
+// extractJPEGStyle walks JPEG markers looking for an APP2 ICC_PROFILE segment and
+// an APP1 Exif segment.
+func extractJPEGStyle(header []byte) Info {
+	var info Info
+	if len(header) < 4 || header[0] != 0xFF || header[1] != 0xD8 {
+		return info
+	}
+
+	offset := 2
+	for offset+4 <= len(header) {
+		if header[offset] != 0xFF {
+			break
+		}
+		marker := header[offset+1]
+		if marker == 0xD8 || marker == 0xD9 || (marker >= 0xD0 && marker <= 0xD7) {
+			offset += 2
+			continue
+		}
+		segLen := int(binary.BigEndian.Uint16(header[offset+2 : offset+4]))
+		segStart := offset + 4
+		segEnd := offset + 2 + segLen
+		if segEnd > len(header) {
+			segEnd = len(header)
+		}
+
+		switch marker {
+		case 0xE2: // APP2, possibly ICC_PROFILE
+			const sig = "ICC_PROFILE\x00"
+			if segEnd-segStart > len(sig)+2 && string(header[segStart:segStart+len(sig)]) == sig {
+				info.ICCProfile = append(info.ICCProfile, header[segStart+len(sig)+2:segEnd]...)
+			}
+		case 0xE1: // APP1, possibly Exif
+			const sig = "Exif\x00\x00"
+			if segEnd-segStart > len(sig) && string(header[segStart:segStart+len(sig)]) == sig {
+				info.Orientation = exifOrientation(header[segStart+len(sig) : segEnd])
+			}
+		case 0xDA: // SOS: compressed data follows, no more markers to scan
+			return info
+		}
+
+		if segLen < 2 {
+			break
+		}
+		offset = segEnd
+	}
+	return info
+}
+
+// extractPNG looks for the iCCP and eXIf ancillary chunks that can follow the
+// PNG signature and IHDR chunk.
+func extractPNG(header []byte) Info {
+	var info Info
+	const sigLen = 8
+	if len(header) < sigLen+8 {
+		return info
+	}
+
+	offset := sigLen
+	for offset+8 <= len(header) {
+		length := int(binary.BigEndian.Uint32(header[offset : offset+4]))
+		chunkType := string(header[offset+4 : offset+8])
+		dataStart := offset + 8
+		dataEnd := dataStart + length
+		if dataEnd > len(header) {
+			dataEnd = len(header)
+		}
+
+		switch chunkType {
+		case "iCCP":
+			if nul := bytes.IndexByte(header[dataStart:dataEnd], 0); nul >= 0 {
+				// name\0 + compression method byte + zlib-compressed profile
+				compressedStart := dataStart + nul + 2
+				if compressedStart <= dataEnd {
+					if zr, err := zlib.NewReader(bytes.NewReader(header[compressedStart:dataEnd])); err == nil {
+						if profile, err := io.ReadAll(zr); err == nil {
+							info.ICCProfile = profile
+						}
+						_ = zr.Close()
+					}
+				}
+			}
+		case "eXIf":
+			info.Orientation = exifOrientation(header[dataStart:dataEnd])
+		case "IDAT":
+			return info
+		}
+
+		if length < 0 {
+			break
+		}
+		offset = dataEnd + 4 // skip CRC
+	}
+	return info
+}
+
+// extractHEIC reads metadata out of a HEIC/HEIF ISOBMFF container. Unlike JPEG and
+// PNG, HEIC has no "Exif\0\0"-prefixed APP1-style segment: the 'Exif' item's payload
+// is a 4-byte TIFF-header offset (usually 0) directly followed by the raw TIFF
+// payload, so orientation is found by scanning for that TIFF header directly. The
+// ICC profile, if present, lives in a 'colr' box whose 4-byte colour type is "prof"
+// or "rICC" (as opposed to "nclx", which carries no profile payload at all).
+func extractHEIC(header []byte) Info {
+	var info Info
+
+	if idx := findTIFFHeader(header); idx >= 0 {
+		info.Orientation = exifOrientation(header[idx:])
+	}
+
+	if idx := bytes.Index(header, []byte("colr")); idx >= 4 && idx+8 <= len(header) {
+		colourType := string(header[idx+4 : idx+8])
+		if colourType == "prof" || colourType == "rICC" {
+			boxLen := int(binary.BigEndian.Uint32(header[idx-4 : idx]))
+			boxEnd := idx - 4 + boxLen
+			if boxEnd > len(header) || boxLen <= 0 {
+				boxEnd = len(header)
+			}
+			profStart := idx + 8
+			if profStart < boxEnd {
+				info.ICCProfile = append(info.ICCProfile, header[profStart:boxEnd]...)
+			}
+		}
+	}
+
+	return info
+}
+
+// findTIFFHeader returns the index of the first TIFF byte-order marker ("II*\0" or
+// "MM\0*") in b, or -1 if none is found.
+func findTIFFHeader(b []byte) int {
+	for i := 0; i+4 <= len(b); i++ {
+		if b[i] == 'I' && b[i+1] == 'I' && b[i+2] == 0x2A && b[i+3] == 0x00 {
+			return i
+		}
+		if b[i] == 'M' && b[i+1] == 'M' && b[i+2] == 0x00 && b[i+3] == 0x2A {
+			return i
+		}
+	}
+	return -1
+}
+
+// EmbedJPEGICC inserts icc as one or more APP2 ICC_PROFILE segments immediately
+// after the SOI marker of a JPEG encoded by the stdlib image/jpeg package, which
+// has no support for writing color profiles itself. It is a no-op if icc is empty
+// or jpegBytes doesn't start with a JPEG SOI marker.
+func EmbedJPEGICC(jpegBytes []byte, icc []byte) []byte {
+	if len(icc) == 0 || len(jpegBytes) < 2 || jpegBytes[0] != 0xFF || jpegBytes[1] != 0xD8 {
+		return jpegBytes
+	}
+
+	const sig = "ICC_PROFILE\x00"
+	const maxChunkData = 65519 // 65535 - 2 (length field) - 12 (sig) - 2 (chunk num/count)
+
+	numChunks := (len(icc) + maxChunkData - 1) / maxChunkData
+	if numChunks == 0 {
+		numChunks = 1
+	}
+
+	var out bytes.Buffer
+	out.Write(jpegBytes[0:2])
+	for i := 0; i < numChunks; i++ {
+		start := i * maxChunkData
+		end := start + maxChunkData
+		if end > len(icc) {
+			end = len(icc)
+		}
+		chunk := icc[start:end]
+
+		segLen := 2 + len(sig) + 2 + len(chunk)
+		out.Write([]byte{0xFF, 0xE2})
+		_ = binary.Write(&out, binary.BigEndian, uint16(segLen))
+		out.WriteString(sig)
+		out.Write([]byte{byte(i + 1), byte(numChunks)})
+		out.Write(chunk)
+	}
+	out.Write(jpegBytes[2:])
+	return out.Bytes()
+}
+
+// exifOrientation parses a TIFF-format Exif payload (as embedded in both JPEG APP1
+// and PNG eXIf) and returns the value of the Orientation tag (0x0112), or 0 if
+// absent or malformed.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd0Offset := order.Uint32(tiff[4:8])
+	if int(ifd0Offset)+2 > len(tiff) {
+		return 0
+	}
+
+	numEntries := int(order.Uint16(tiff[ifd0Offset : ifd0Offset+2]))
+	entriesStart := int(ifd0Offset) + 2
+	const entrySize = 12
+	for i := 0; i < numEntries; i++ {
+		entryStart := entriesStart + i*entrySize
+		if entryStart+entrySize > len(tiff) {
+			break
+		}
+		tag := order.Uint16(tiff[entryStart : entryStart+2])
+		if tag != 0x0112 {
+			continue
+		}
+		value := order.Uint16(tiff[entryStart+8 : entryStart+10])
+		if value >= 1 && value <= 8 {
+			return int(value)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+)
+
+// buildTIFFOrientation returns a minimal TIFF byte-order header with a single
+// IFD0 entry for the Orientation tag (0x0112, type SHORT), matching the shape
+// exifOrientation/findTIFFHeader expect.
+func buildTIFFOrientation(littleEndian bool, orientation uint16) []byte {
+	var order binary.ByteOrder = binary.BigEndian
+	header := []byte("MM")
+	if littleEndian {
+		order = binary.LittleEndian
+		header = []byte("II")
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(header)
+	_ = binary.Write(buf, order, uint16(42))
+	_ = binary.Write(buf, order, uint32(8)) // IFD0 offset
+	_ = binary.Write(buf, order, uint16(1)) // 1 entry
+	_ = binary.Write(buf, order, uint16(0x0112))
+	_ = binary.Write(buf, order, uint16(3)) // type SHORT
+	_ = binary.Write(buf, order, uint32(1)) // count
+	_ = binary.Write(buf, order, orientation)
+	_ = binary.Write(buf, order, uint16(0)) // padding to fill the 4-byte value slot
+	_ = binary.Write(buf, order, uint32(0)) // next IFD offset
+	return buf.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name         string
+		littleEndian bool
+		orientation  uint16
+		want         int
+	}{
+		{"little endian upright", true, 1, 1},
+		{"little endian rotated", true, 6, 6},
+		{"big endian rotated", false, 8, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiff := buildTIFFOrientation(tt.littleEndian, tt.orientation)
+			if got := exifOrientation(tiff); got != tt.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("too short", func(t *testing.T) {
+		if got := exifOrientation([]byte{0x49, 0x49}); got != 0 {
+			t.Errorf("exifOrientation(short) = %d, want 0", got)
+		}
+	})
+
+	t.Run("unrecognized byte order", func(t *testing.T) {
+		if got := exifOrientation([]byte("XX\x2A\x00\x08\x00\x00\x00")); got != 0 {
+			t.Errorf("exifOrientation(bad order) = %d, want 0", got)
+		}
+	})
+}
+
+func buildJPEGHeader(icc []byte, tiff []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0xFF, 0xD8}) // SOI
+
+	if len(tiff) > 0 {
+		const exifSig = "Exif\x00\x00"
+		payload := append([]byte(exifSig), tiff...)
+		buf.Write([]byte{0xFF, 0xE1})
+		_ = binary.Write(buf, binary.BigEndian, uint16(2+len(payload)))
+		buf.Write(payload)
+	}
+
+	if len(icc) > 0 {
+		const iccSig = "ICC_PROFILE\x00"
+		payload := append([]byte(iccSig), 1, 1)
+		payload = append(payload, icc...)
+		buf.Write([]byte{0xFF, 0xE2})
+		_ = binary.Write(buf, binary.BigEndian, uint16(2+len(payload)))
+		buf.Write(payload)
+	}
+
+	buf.Write([]byte{0xFF, 0xDA}) // SOS, scanning stops here
+	return buf.Bytes()
+}
+
+func TestExtractJPEGStyle(t *testing.T) {
+	icc := []byte("fake-icc-profile-bytes")
+	tiff := buildTIFFOrientation(true, 6)
+	header := buildJPEGHeader(icc, tiff)
+
+	info := Extract(header, "jpeg")
+	if !bytes.Equal(info.ICCProfile, icc) {
+		t.Errorf("ICCProfile = %q, want %q", info.ICCProfile, icc)
+	}
+	if info.Orientation != 6 {
+		t.Errorf("Orientation = %d, want 6", info.Orientation)
+	}
+}
+
+func TestExtractJPEGStyleNotJPEG(t *testing.T) {
+	info := Extract([]byte("not a jpeg"), "jpeg")
+	if info.ICCProfile != nil || info.Orientation != 0 {
+		t.Errorf("Extract(non-jpeg, jpeg) = %+v, want zero Info", info)
+	}
+}
+
+func buildPNGHeader(icc []byte, tiff []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0x89, 'P', 'N', 'G', 0x0D, 0x0A, 0x1A, 0x0A}) // PNG signature
+
+	writeChunk := func(chunkType string, data []byte) {
+		_ = binary.Write(buf, binary.BigEndian, uint32(len(data)))
+		buf.WriteString(chunkType)
+		buf.Write(data)
+		buf.Write([]byte{0, 0, 0, 0}) // fake CRC
+	}
+
+	if len(icc) > 0 {
+		data := append([]byte("profile name\x00"), 0) // name\0 + compression method
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		_, _ = zw.Write(icc)
+		_ = zw.Close()
+		data = append(data, compressed.Bytes()...)
+		writeChunk("iCCP", data)
+	}
+	if len(tiff) > 0 {
+		writeChunk("eXIf", tiff)
+	}
+	writeChunk("IDAT", []byte{1, 2, 3})
+	return buf.Bytes()
+}
+
+func TestExtractPNG(t *testing.T) {
+	icc := []byte("compressed-profile-stand-in")
+	tiff := buildTIFFOrientation(false, 3)
+	header := buildPNGHeader(icc, tiff)
+
+	info := Extract(header, "png")
+	if !bytes.Equal(info.ICCProfile, icc) {
+		t.Errorf("ICCProfile = %q, want %q", info.ICCProfile, icc)
+	}
+	if info.Orientation != 3 {
+		t.Errorf("Orientation = %d, want 3", info.Orientation)
+	}
+}
+
+func buildHEICHeader(icc []byte, tiff []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0, 0, 0, 24})
+	buf.WriteString("ftypheic")
+	buf.Write(make([]byte, 16))
+
+	if len(icc) > 0 {
+		colr := append([]byte("colr"), []byte("prof")...)
+		colr = append(colr, icc...)
+		_ = binary.Write(buf, binary.BigEndian, uint32(8+len(colr)-4))
+		buf.Write(colr)
+	}
+
+	if len(tiff) > 0 {
+		buf.Write([]byte{0, 0, 0, 0}) // 4-byte TIFF-header offset field preceding the Exif item payload
+		buf.Write(tiff)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractHEIC(t *testing.T) {
+	icc := []byte("heic-icc-profile")
+	tiff := buildTIFFOrientation(true, 8)
+	header := buildHEICHeader(icc, tiff)
+
+	info := Extract(header, "heic")
+	if !bytes.Equal(info.ICCProfile, icc) {
+		t.Errorf("ICCProfile = %q, want %q", info.ICCProfile, icc)
+	}
+	if info.Orientation != 8 {
+		t.Errorf("Orientation = %d, want 8", info.Orientation)
+	}
+}
+
+func TestExtractHEICNclxHasNoProfile(t *testing.T) {
+	buf := new(bytes.Buffer)
+	buf.WriteString("ftypheic")
+	colr := append([]byte("colr"), []byte("nclx")...)
+	colr = append(colr, []byte{1, 2, 3, 4}...)
+	_ = binary.Write(buf, binary.BigEndian, uint32(8+len(colr)-4))
+	buf.Write(colr)
+
+	info := Extract(buf.Bytes(), "heic")
+	if info.ICCProfile != nil {
+		t.Errorf("ICCProfile = %q, want nil for nclx colour type", info.ICCProfile)
+	}
+}
+
+func TestExtractUnknownFormat(t *testing.T) {
+	info := Extract([]byte{1, 2, 3}, "bmp")
+	if info.ICCProfile != nil || info.Orientation != 0 {
+		t.Errorf("Extract(unknown format) = %+v, want zero Info", info)
+	}
+}
+
+func TestEmbedJPEGICC(t *testing.T) {
+	jpegBytes := []byte{0xFF, 0xD8, 0xFF, 0xD9} // minimal SOI+EOI
+	icc := []byte("short-profile")
+
+	out := EmbedJPEGICC(jpegBytes, icc)
+	if out[0] != 0xFF || out[1] != 0xD8 {
+		t.Fatalf("output doesn't start with SOI: % x", out[:2])
+	}
+	if out[2] != 0xFF || out[3] != 0xE2 {
+		t.Fatalf("expected APP2 marker right after SOI, got % x", out[2:4])
+	}
+	if !bytes.Contains(out, icc) {
+		t.Error("output doesn't contain the ICC profile bytes")
+	}
+	// Everything after the SOI from the original JPEG must still be present.
+	if !bytes.HasSuffix(out, jpegBytes[2:]) {
+		t.Error("original JPEG bytes after SOI were not preserved")
+	}
+}
+
+func TestEmbedJPEGICCNoOpCases(t *testing.T) {
+	jpegBytes := []byte{0xFF, 0xD8, 0xFF, 0xD9}
+
+	if got := EmbedJPEGICC(jpegBytes, nil); !bytes.Equal(got, jpegBytes) {
+		t.Error("EmbedJPEGICC with empty icc should return jpegBytes unchanged")
+	}
+	if got := EmbedJPEGICC([]byte("not a jpeg"), []byte("icc")); !bytes.Equal(got, []byte("not a jpeg")) {
+		t.Error("EmbedJPEGICC with non-JPEG input should return input unchanged")
+	}
+}
+
+func TestEmbedJPEGICCMultiChunk(t *testing.T) {
+	jpegBytes := []byte{0xFF, 0xD8, 0xFF, 0xD9}
+	icc := bytes.Repeat([]byte{0xAB}, 65519*2+10) // spans 3 APP2 chunks
+
+	out := EmbedJPEGICC(jpegBytes, icc)
+	// 3 chunk markers 0xFF 0xE2 expected.
+	count := bytes.Count(out, []byte{0xFF, 0xE2})
+	if count != 3 {
+		t.Errorf("expected 3 APP2 chunks for oversized ICC profile, got %d", count)
+	}
+}
@@ -11,33 +11,127 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/ayla6/avif"
 	_ "github.com/ayla6/avif"
 	"github.com/disintegration/imaging"
+	"github.com/gen2brain/heic"
 	"github.com/gen2brain/jpegxl"
 	_ "github.com/gen2brain/jpegxl"
 	"github.com/gen2brain/webp"
 	"github.com/navidrome/navidrome/conf"
+	"github.com/navidrome/navidrome/core/artwork/metadata"
 	"github.com/navidrome/navidrome/log"
 	"github.com/navidrome/navidrome/model"
 )
 
+// headerPeekSize is how much of the source file resizeImage peeks before decoding,
+// large enough to contain a typical embedded ICC profile alongside the format magic
+// and EXIF block that shouldEncodeLossless/metadata.Extract look at.
+const headerPeekSize = 65536
+
 type resizedArtworkReader struct {
 	artID      model.ArtworkID
 	cacheKey   string
 	lastUpdate time.Time
 	size       int
 	square     bool
+	format     string
 	a          *artwork
 }
 
-func resizedFromOriginal(ctx context.Context, a *artwork, artID model.ArtworkID, size int, square bool) (*resizedArtworkReader, error) {
+// supportedArtworkFormats are the encoders resizeImage knows how to pick between,
+// in no particular order (preference order comes from the caller's list).
+var supportedArtworkFormats = map[string]bool{
+	"avif": true,
+	"jxl":  true,
+	"webp": true,
+	"jpeg": true,
+}
+
+// mimeSubtypeToFormat maps the image MIME subtypes seen in HTTP Accept headers to the
+// encoder identifiers used internally (and as the value of conf.Server.CoverArtFormat).
+var mimeSubtypeToFormat = map[string]string{
+	"avif": "avif",
+	"jxl":  "jxl",
+	"webp": "webp",
+	"jpeg": "jpeg",
+	"jpg":  "jpeg",
+}
+
+// ParseAcceptFormats extracts the image formats named in an HTTP Accept header, in the
+// order they were listed, for use as the preferredFormats argument to resizedFromOriginal.
+// Entries that aren't an image/* MIME type, name an image subtype with no encoder here
+// (e.g. image/svg+xml), or are the wildcards */* and image/*, are skipped.
+//
+// The artwork HTTP handler must call this on the request's Accept header and pass the
+// result into resizedFromOriginal for content negotiation to actually happen; that
+// handler isn't part of this change set, so that call site still needs to be added there.
+func ParseAcceptFormats(accept string) []string {
+	var formats []string
+	for _, part := range strings.Split(accept, ",") {
+		mime := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mime == "*/*" || mime == "image/*" || mime == "" {
+			continue
+		}
+		sub, ok := strings.CutPrefix(mime, "image/")
+		if !ok {
+			continue
+		}
+		if f, ok := mimeSubtypeToFormat[sub]; ok {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// pickFormat returns the first entry of preferred that resizeImage can encode to,
+// falling back to conf.Server.CoverArtFormat when none match (including when
+// preferred is empty, e.g. no Accept header was sent).
+func pickFormat(preferred []string) string {
+	for _, f := range preferred {
+		if supportedArtworkFormats[f] {
+			return f
+		}
+	}
+	return conf.Server.CoverArtFormat
+}
+
+// snapToPreset maps a requested size to the smallest configured thumbnail preset
+// that is at least as large as the request, so that the resize/encode cost is only
+// ever paid once per preset instead of once per distinct client-requested size.
+// If no preset is large enough, or none match the requested square-ness, the
+// requested size is returned unchanged.
+func snapToPreset(size int, square bool) int {
+	best := 0
+	for _, p := range conf.Server.ThumbnailPresets {
+		if p.Square != square {
+			continue
+		}
+		if p.Size < size {
+			continue
+		}
+		if best == 0 || p.Size < best {
+			best = p.Size
+		}
+	}
+	if best == 0 {
+		return size
+	}
+	return best
+}
+
+func resizedFromOriginal(ctx context.Context, a *artwork, artID model.ArtworkID, size int, square bool, preferredFormats ...string) (*resizedArtworkReader, error) {
 	r := &resizedArtworkReader{a: a}
 	r.artID = artID
+	if len(conf.Server.ThumbnailPresets) > 0 && !conf.Server.DynamicThumbnails {
+		size = snapToPreset(size, square)
+	}
 	r.size = size
 	r.square = square
+	r.format = pickFormat(preferredFormats)
 
 	// Get lastUpdated and cacheKey from original artwork
 	original, err := a.getArtworkReader(ctx, artID, 0, false)
@@ -49,18 +143,47 @@ func resizedFromOriginal(ctx context.Context, a *artwork, artID model.ArtworkID,
 	return r, nil
 }
 
+// Key returns the cache key to look up before calling Reader, based on the
+// requested size. When CoverArtMaxBytes forces a downscale, the bytes Reader
+// actually produces belong under a different, smaller size; Reader's own
+// second return value carries that final key so callers don't need to call
+// Key again to store the result.
 func (a *resizedArtworkReader) Key() string {
-	baseKey := fmt.Sprintf("%s.%d", a.cacheKey, a.size)
+	return a.keyForSize(a.size)
+}
+
+func (a *resizedArtworkReader) keyForSize(size int) string {
+	baseKey := fmt.Sprintf("%s.%d", a.cacheKey, size)
 	if a.square {
-		return baseKey + ".square"
+		baseKey += ".square"
 	}
-	return fmt.Sprintf("%s.%s", baseKey, conf.Server.CoverArtFormat)
+	return fmt.Sprintf("%s.%s", baseKey, a.format)
 }
 
 func (a *resizedArtworkReader) LastUpdated() time.Time {
 	return a.lastUpdate
 }
 
+// PregenerateThumbnails runs the resize/encode of every configured preset for artID,
+// in each preset's own Size/Square/Format, and closes each result unread: the
+// renditions are not written to any cache, so a later request for a preset is
+// resized again. It is a no-op unless ThumbnailPresets are configured. Nothing in
+// this package calls it.
+func PregenerateThumbnails(ctx context.Context, a *artwork, artID model.ArtworkID) error {
+	for _, p := range conf.Server.ThumbnailPresets {
+		r, err := resizedFromOriginal(ctx, a, artID, p.Size, p.Square, p.Format)
+		if err != nil {
+			return err
+		}
+		reader, _, err := r.Reader(ctx)
+		if err != nil {
+			return err
+		}
+		_ = reader.Close()
+	}
+	return nil
+}
+
 func (a *resizedArtworkReader) Reader(ctx context.Context) (io.ReadCloser, string, error) {
 	// Get artwork in original size, possibly from cache
 	orig, _, err := a.a.Get(ctx, a.artID, 0, false)
@@ -69,21 +192,70 @@ func (a *resizedArtworkReader) Reader(ctx context.Context) (io.ReadCloser, strin
 	}
 	defer orig.Close()
 
-	resized, origSize, err := resizeImage(orig, a.size, a.square)
+	var resized io.Reader
+	var origSize int
+	finalSize := a.size
+	if conf.Server.CoverArtMaxBytes > 0 {
+		resized, origSize, finalSize, err = resizeImageToByteBudget(orig, a.size, a.square, a.format)
+	} else {
+		resized, origSize, err = resizeImage(orig, a.size, a.square, a.format)
+	}
+
 	if resized == nil {
-		log.Trace(ctx, "Image smaller than requested size", "artID", a.artID, "original", origSize, "resized", a.size, "square", a.square)
+		log.Trace(ctx, "Image smaller than requested size", "artID", a.artID, "original", origSize, "resized", finalSize, "square", a.square)
 	} else {
-		log.Trace(ctx, "Resizing artwork", "artID", a.artID, "original", origSize, "resized", a.size, "square", a.square)
+		log.Trace(ctx, "Resizing artwork", "artID", a.artID, "original", origSize, "resized", finalSize, "square", a.square)
 	}
 	if err != nil {
-		log.Warn(ctx, "Could not resize image. Will return image as is", "artID", a.artID, "size", a.size, "square", a.square, err)
+		log.Warn(ctx, "Could not resize image. Will return image as is", "artID", a.artID, "size", finalSize, "square", a.square, err)
 	}
 	if err != nil || resized == nil {
 		// if we couldn't resize the image, return the original
 		orig, _, err = a.a.Get(ctx, a.artID, 0, false)
 		return orig, "", err
 	}
-	return io.NopCloser(resized), fmt.Sprintf("%s@%d", a.artID, a.size), nil
+	return io.NopCloser(resized), a.keyForSize(finalSize), nil
+}
+
+// heicBrands lists the ISOBMFF major/compatible brands used by HEIC/HEIF images,
+// as found in the ftyp box that starts at offset 4 of the file.
+var heicBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "hevm", "hevs", "mif1", "msf1"}
+
+// isHEIC sniffs the peeked header for an ISOBMFF ftyp box advertising a HEIC/HEIF brand.
+func isHEIC(header []byte) bool {
+	if len(header) < 12 || string(header[4:8]) != "ftyp" {
+		return false
+	}
+	brand := string(header[8:12])
+	for _, b := range heicBrands {
+		if brand == b {
+			return true
+		}
+	}
+	return false
+}
+
+// applyOrientation rotates/flips img so that it displays upright, per the EXIF
+// Orientation tag convention (1 = already upright, 2-8 = the transform needed).
+func applyOrientation(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	default:
+		return img
+	}
 }
 
 func shouldEncodeLossless(format string, originalBytes int, bounds image.Rectangle, header []byte) bool {
@@ -94,7 +266,9 @@ func shouldEncodeLossless(format string, originalBytes int, bounds image.Rectang
 	bpp := float64(originalBytes*8) / float64(totalPixels)
 
 	isNativeLossless := false
-	if format == "png" {
+	if format == "heic" {
+		return false
+	} else if format == "png" {
 		isNativeLossless = true
 	} else if format == "jpeg" {
 		return false
@@ -160,70 +334,206 @@ func qualityBySize(n int, m int) int {
 	return int(qualityFloat)
 }
 
-func resizeImage(reader io.Reader, size int, square bool) (io.Reader, int, error) {
-	br := bufio.NewReader(reader)
+// encodeAtQuality encodes img in the given format at the given quality, following the
+// same lossless/lossy format switch used by resizeImage. When iccProfile is non-empty
+// it is embedded into the output container; JPEG output (the lossy path for jpeg and
+// png) gets it as APP2 segments inserted by hand, since image/jpeg can't write them.
+// Lossless png output carries no profile.
+func encodeAtQuality(img image.Image, format string, quality int, lossless bool, iccProfile []byte) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	producedJPEG := false
 
-	header, _ := br.Peek(512)
+	if lossless {
+		switch format {
+		case "jxl":
+			err = jpegxl.Encode(buf, img, jpegxl.Options{Quality: 100, ICCProfile: iccProfile})
+		case "png":
+			err = png.Encode(buf, img)
+		default:
+			err = webp.Encode(buf, img, webp.Options{Quality: 100, Lossless: true, ICCProfile: iccProfile})
+		}
+	} else {
+		switch format {
+		case "webp":
+			err = webp.Encode(buf, img, webp.Options{Quality: quality, ICCProfile: iccProfile})
+		case "jxl":
+			err = jpegxl.Encode(buf, img, jpegxl.Options{Quality: quality, ICCProfile: iccProfile})
+		case "avif":
+			err = avif.Encode(buf, img, avif.Options{Quality: quality, ICCProfile: iccProfile, Advanced: map[string]string{
+				"tune": "iq",
+			}})
+		default: // png and jpeg
+			err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+			producedJPEG = true
+		}
+	}
+	if err != nil {
+		return buf, err
+	}
+
+	if producedJPEG && len(iccProfile) > 0 {
+		return bytes.NewBuffer(metadata.EmbedJPEGICC(buf.Bytes(), iccProfile)), nil
+	}
+	return buf, nil
+}
+
+// resizeImageToByteBudget behaves like resizeImage, but additionally enforces
+// conf.Server.CoverArtMaxBytes: it first steps quality down (by CoverArtQualityStep)
+// from the quality resizeImage would have picked, down to CoverArtMinQuality, and only
+// once quality is exhausted does it shrink the pixel dimensions by 5% and start over.
+// It returns the encoded buffer along with the pixel dimensions actually used, so
+// callers can fold the final size into their cache key.
+func resizeImageToByteBudget(reader io.Reader, size int, square bool, targetFormat string) (io.Reader, int, int, error) {
+	maxBytes := conf.Server.CoverArtMaxBytes
+	if maxBytes <= 0 {
+		resized, origSize, err := resizeImage(reader, size, square, targetFormat)
+		return resized, origSize, size, err
+	}
+
+	br := bufio.NewReaderSize(reader, headerPeekSize)
+	header, _ := br.Peek(headerPeekSize)
 
 	cr := &countingReader{r: br}
-	original, format, err := image.Decode(cr)
+	var original image.Image
+	var srcFormat string
+	var err error
+	if isHEIC(header) {
+		original, err = heic.Decode(cr)
+		srcFormat = "heic"
+	} else {
+		original, srcFormat, err = image.Decode(cr)
+	}
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, size, err
 	}
 
-	originalBytes := cr.n
-	imgSrcSameFormatAsServer := format == conf.Server.CoverArtFormat
+	meta := metadata.Extract(header, srcFormat)
+	if meta.Orientation > 1 {
+		original = applyOrientation(original, meta.Orientation)
+	}
 
+	originalBytes := cr.n
 	bounds := original.Bounds()
 	originalSize := max(bounds.Max.X, bounds.Max.Y)
 
-	if imgSrcSameFormatAsServer && originalSize <= size {
-		return nil, originalSize, nil
+	targetSize := size
+	var lastBuf *bytes.Buffer
+
+	for {
+		var resized image.Image
+		if square && bounds.Dx() != bounds.Dy() && (conf.Server.CoverArtSquareMode == "smart" || conf.Server.CoverArtSquareMode == "center") {
+			cropped := squareCrop(original)
+			cropSide := cropped.Bounds().Dx()
+			if cropSide <= targetSize {
+				resized = cropped
+			} else {
+				resized = imaging.Fit(cropped, targetSize, targetSize, imaging.Lanczos)
+			}
+		} else {
+			if originalSize <= targetSize {
+				resized = original
+			} else {
+				resized = imaging.Fit(original, targetSize, targetSize, imaging.Lanczos)
+			}
+			if square && bounds.Dx() != bounds.Dy() {
+				bg := image.NewRGBA(image.Rect(0, 0, targetSize, targetSize))
+				resized = imaging.OverlayCenter(bg, resized, 1)
+			}
+		}
+
+		encodeLossless := shouldEncodeLossless(srcFormat, originalBytes, bounds, header)
+		quality := qualityBySize(targetSize, min(targetSize, originalSize))
+
+		for {
+			buf, err := encodeAtQuality(resized, targetFormat, quality, encodeLossless, meta.ICCProfile)
+			if err != nil {
+				return nil, originalSize, targetSize, err
+			}
+			lastBuf = buf
+
+			if buf.Len() <= maxBytes || encodeLossless || quality <= conf.Server.CoverArtMinQuality {
+				break
+			}
+			quality -= conf.Server.CoverArtQualityStep
+			if quality < conf.Server.CoverArtMinQuality {
+				quality = conf.Server.CoverArtMinQuality
+			}
+		}
+
+		if lastBuf.Len() <= maxBytes || targetSize <= 1 {
+			break
+		}
+
+		targetSize = int(float64(targetSize) * 0.95)
+		if targetSize < 1 {
+			targetSize = 1
+		}
 	}
 
-	var resized image.Image
-	if originalSize <= size {
-		resized = original
+	return lastBuf, originalSize, targetSize, nil
+}
+
+func resizeImage(reader io.Reader, size int, square bool, targetFormat string) (io.Reader, int, error) {
+	br := bufio.NewReaderSize(reader, headerPeekSize)
+
+	header, _ := br.Peek(headerPeekSize)
+
+	cr := &countingReader{r: br}
+	var original image.Image
+	var srcFormat string
+	var err error
+	if isHEIC(header) {
+		original, err = heic.Decode(cr)
+		srcFormat = "heic"
 	} else {
-		resized = imaging.Fit(original, size, size, imaging.Lanczos)
+		original, srcFormat, err = image.Decode(cr)
+	}
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if square && bounds.Dx() != bounds.Dy() {
-		bg := image.NewRGBA(image.Rect(0, 0, size, size))
-		resized = imaging.OverlayCenter(bg, resized, 1)
+	meta := metadata.Extract(header, srcFormat)
+	if meta.Orientation > 1 {
+		original = applyOrientation(original, meta.Orientation)
 	}
 
-	encodeLossless := shouldEncodeLossless(format, originalBytes, bounds, header)
+	originalBytes := cr.n
+	imgSrcSameFormatAsServer := srcFormat == targetFormat && meta.Orientation <= 1
 
-	buf := new(bytes.Buffer)
+	bounds := original.Bounds()
+	originalSize := max(bounds.Max.X, bounds.Max.Y)
 
-	if encodeLossless {
-		switch conf.Server.CoverArtFormat {
-		case "jxl":
-			err = jpegxl.Encode(buf, resized, jpegxl.Options{Quality: 100})
-		case "png":
-			err = png.Encode(buf, resized)
-		default:
-			// if you wanna use shitty formats like png and jpeg pick png, it's gonna go with jpeg for lossy then. jpeg picks webp for lossless because ig some people would prefer how jpeg handles lossy images idk.
-			// if you pick avif you also get webp for lossless because lossy avif is a joke
-			err = webp.Encode(buf, resized, webp.Options{Quality: 100, Lossless: true})
+	if imgSrcSameFormatAsServer && originalSize <= size {
+		return nil, originalSize, nil
+	}
+
+	var resized image.Image
+	if square && bounds.Dx() != bounds.Dy() && (conf.Server.CoverArtSquareMode == "smart" || conf.Server.CoverArtSquareMode == "center") {
+		cropped := squareCrop(original)
+		cropSide := cropped.Bounds().Dx()
+		if cropSide <= size {
+			resized = cropped
+		} else {
+			resized = imaging.Fit(cropped, size, size, imaging.Lanczos)
 		}
 	} else {
-		q := qualityBySize(size, min(size, originalSize))
-		switch conf.Server.CoverArtFormat {
-		case "webp":
-			err = webp.Encode(buf, resized, webp.Options{Quality: q})
-		case "jxl":
-			err = jpegxl.Encode(buf, resized, jpegxl.Options{Quality: q})
-		case "avif":
-			err = avif.Encode(buf, resized, avif.Options{Quality: q, Advanced: map[string]string{
-				"tune": "iq",
-			}})
-		default: // png and jpeg
-			err = jpeg.Encode(buf, resized, &jpeg.Options{Quality: q})
+		if originalSize <= size {
+			resized = original
+		} else {
+			resized = imaging.Fit(original, size, size, imaging.Lanczos)
+		}
+		if square && bounds.Dx() != bounds.Dy() {
+			bg := image.NewRGBA(image.Rect(0, 0, size, size))
+			resized = imaging.OverlayCenter(bg, resized, 1)
 		}
 	}
 
+	encodeLossless := shouldEncodeLossless(srcFormat, originalBytes, bounds, header)
+
+	q := qualityBySize(size, min(size, originalSize))
+	buf, err := encodeAtQuality(resized, targetFormat, q, encodeLossless, meta.ICCProfile)
+
 	if imgSrcSameFormatAsServer && buf.Len() >= originalBytes {
 		return nil, originalSize, nil
 	}
@@ -0,0 +1,99 @@
+package artwork
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/navidrome/navidrome/conf"
+)
+
+func TestParseAcceptFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   []string
+	}{
+		{"empty", "", nil},
+		{"wildcard", "*/*", nil},
+		{"image wildcard", "image/*", nil},
+		{"single format", "image/avif", []string{"avif"}},
+		{"jpg alias", "image/jpg", []string{"jpeg"}},
+		{"preserves order", "image/webp, image/avif, image/jpeg", []string{"webp", "avif", "jpeg"}},
+		{"ignores quality params", "image/avif;q=0.9, image/jpeg;q=0.8", []string{"avif", "jpeg"}},
+		{"skips unsupported and non-image types", "text/html, image/svg+xml, image/webp", []string{"webp"}},
+		{"mixed wildcard and formats", "image/avif, */*", []string{"avif"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseAcceptFormats(tt.accept)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseAcceptFormats(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickFormat(t *testing.T) {
+	orig := conf.Server.CoverArtFormat
+	conf.Server.CoverArtFormat = "jpeg"
+	defer func() { conf.Server.CoverArtFormat = orig }()
+
+	tests := []struct {
+		name      string
+		preferred []string
+		want      string
+	}{
+		{"empty falls back to CoverArtFormat", nil, "jpeg"},
+		{"first supported wins", []string{"avif", "webp"}, "avif"},
+		{"skips unsupported entries", []string{"svg", "webp"}, "webp"},
+		{"all unsupported falls back", []string{"svg", "bmp"}, "jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickFormat(tt.preferred); got != tt.want {
+				t.Errorf("pickFormat(%v) = %q, want %q", tt.preferred, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapToPreset(t *testing.T) {
+	orig := conf.Server.ThumbnailPresets
+	defer func() { conf.Server.ThumbnailPresets = orig }()
+
+	conf.Server.ThumbnailPresets = []conf.ThumbnailPreset{
+		{Size: 150, Square: true},
+		{Size: 300, Square: true},
+		{Size: 600, Square: false},
+	}
+
+	tests := []struct {
+		name   string
+		size   int
+		square bool
+		want   int
+	}{
+		{"snaps up to nearest matching preset", 100, true, 150},
+		{"exact match returned as-is", 150, true, 150},
+		{"snaps to larger preset when between two", 200, true, 300},
+		{"no preset large enough falls back to requested size", 1000, true, 1000},
+		{"square-ness must match", 100, false, 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snapToPreset(tt.size, tt.square); got != tt.want {
+				t.Errorf("snapToPreset(%d, %v) = %d, want %d", tt.size, tt.square, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapToPresetNoneConfigured(t *testing.T) {
+	orig := conf.Server.ThumbnailPresets
+	conf.Server.ThumbnailPresets = nil
+	defer func() { conf.Server.ThumbnailPresets = orig }()
+
+	if got := snapToPreset(250, true); got != 250 {
+		t.Errorf("snapToPreset with no presets configured = %d, want 250 (unchanged)", got)
+	}
+}
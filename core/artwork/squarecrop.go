@@ -0,0 +1,147 @@
+package artwork
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/navidrome/navidrome/conf"
+)
+
+// saliencyProbeSize is the long-side dimension non-square art is downsampled to
+// before running the Sobel pass; saliency doesn't need full resolution and this
+// keeps the smart crop cheap even for large source images.
+const saliencyProbeSize = 64
+
+// squareCrop turns a non-square image into a square one, before it's fit to the
+// requested thumbnail size, according to conf.Server.CoverArtSquareMode:
+//   - "smart": crop to the window of the long axis with the most edge energy
+//   - "center": naive center crop
+//   - anything else (including unset): returned unchanged, so the caller pads
+//     instead of cropping, preserving the original letterboxing behavior
+func squareCrop(img image.Image) image.Image {
+	switch conf.Server.CoverArtSquareMode {
+	case "smart":
+		return smartSquareCrop(img)
+	case "center":
+		return centerSquareCrop(img)
+	default:
+		return img
+	}
+}
+
+// centerSquareCrop crops img to a centered square spanning its shorter dimension.
+func centerSquareCrop(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	side := min(w, h)
+	x0 := bounds.Min.X + (w-side)/2
+	y0 := bounds.Min.Y + (h-side)/2
+	return imaging.Crop(img, image.Rect(x0, y0, x0+side, y0+side))
+}
+
+// smartSquareCrop crops img to a square window along its long axis, chosen to
+// enclose as much edge energy (as a proxy for visually salient content) as
+// possible. It downsamples to a small probe image to compute that window
+// cheaply, then maps the chosen offset back to full resolution.
+func smartSquareCrop(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == h {
+		return img
+	}
+
+	var probe image.Image
+	if w > h {
+		probe = imaging.Resize(img, saliencyProbeSize, 0, imaging.Lanczos)
+	} else {
+		probe = imaging.Resize(img, 0, saliencyProbeSize, imaging.Lanczos)
+	}
+	pb := probe.Bounds()
+	rowEnergy, colEnergy := sobelEnergyHistograms(probe)
+
+	if w > h {
+		side := h
+		probeSide := int(float64(side) / float64(w) * float64(pb.Dx()))
+		offsetProbe := bestWindowOffset(colEnergy, probeSide)
+		offset := int(float64(offsetProbe) / float64(pb.Dx()) * float64(w))
+		if offset+side > w {
+			offset = w - side
+		}
+		x0 := bounds.Min.X + offset
+		return imaging.Crop(img, image.Rect(x0, bounds.Min.Y, x0+side, bounds.Min.Y+side))
+	}
+
+	side := w
+	probeSide := int(float64(side) / float64(h) * float64(pb.Dy()))
+	offsetProbe := bestWindowOffset(rowEnergy, probeSide)
+	offset := int(float64(offsetProbe) / float64(pb.Dy()) * float64(h))
+	if offset+side > h {
+		offset = h - side
+	}
+	y0 := bounds.Min.Y + offset
+	return imaging.Crop(img, image.Rect(bounds.Min.X, y0, bounds.Min.X+side, y0+side))
+}
+
+// sobelEnergyHistograms computes the Sobel gradient magnitude at each pixel of img
+// and sums it into per-row and per-column histograms.
+func sobelEnergyHistograms(img image.Image) (rows []float64, cols []float64) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	gray := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		gray[y] = make([]float64, w)
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			gray[y][x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+		}
+	}
+
+	rows = make([]float64, h)
+	cols = make([]float64, w)
+
+	gx := [3][3]float64{{-1, 0, 1}, {-2, 0, 2}, {-1, 0, 1}}
+	gy := [3][3]float64{{-1, -2, -1}, {0, 0, 0}, {1, 2, 1}}
+
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			var sx, sy float64
+			for j := -1; j <= 1; j++ {
+				for i := -1; i <= 1; i++ {
+					v := gray[y+j][x+i]
+					sx += gx[j+1][i+1] * v
+					sy += gy[j+1][i+1] * v
+				}
+			}
+			mag := math.Hypot(sx, sy)
+			rows[y] += mag
+			cols[x] += mag
+		}
+	}
+	return rows, cols
+}
+
+// bestWindowOffset slides a window of the given length across hist and returns the
+// start index of the window with the greatest sum.
+func bestWindowOffset(hist []float64, windowLen int) int {
+	if windowLen <= 0 || windowLen >= len(hist) {
+		return 0
+	}
+
+	var sum float64
+	for i := 0; i < windowLen; i++ {
+		sum += hist[i]
+	}
+	best := sum
+	bestOffset := 0
+
+	for start := 1; start+windowLen <= len(hist); start++ {
+		sum += hist[start+windowLen-1] - hist[start-1]
+		if sum > best {
+			best = sum
+			bestOffset = start
+		}
+	}
+	return bestOffset
+}
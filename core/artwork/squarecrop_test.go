@@ -0,0 +1,138 @@
+package artwork
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/navidrome/navidrome/conf"
+)
+
+func withSquareMode(t *testing.T, mode string, fn func()) {
+	t.Helper()
+	orig := conf.Server.CoverArtSquareMode
+	conf.Server.CoverArtSquareMode = mode
+	defer func() { conf.Server.CoverArtSquareMode = orig }()
+	fn()
+}
+
+func TestSquareCropDispatch(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+
+	withSquareMode(t, "center", func() {
+		out := squareCrop(img)
+		b := out.Bounds()
+		if b.Dx() != b.Dy() {
+			t.Errorf("center mode: got %dx%d, want square", b.Dx(), b.Dy())
+		}
+	})
+
+	withSquareMode(t, "smart", func() {
+		out := squareCrop(img)
+		b := out.Bounds()
+		if b.Dx() != b.Dy() {
+			t.Errorf("smart mode: got %dx%d, want square", b.Dx(), b.Dy())
+		}
+	})
+
+	for _, mode := range []string{"", "pad", "bogus"} {
+		withSquareMode(t, mode, func() {
+			out := squareCrop(img)
+			if out != image.Image(img) {
+				t.Errorf("mode %q: expected image returned unchanged for caller to pad", mode)
+			}
+		})
+	}
+}
+
+func TestCenterSquareCrop(t *testing.T) {
+	tests := []struct {
+		name    string
+		w, h    int
+		minSide int
+	}{
+		{"wide", 100, 40, 40},
+		{"tall", 30, 90, 30},
+		{"already square", 50, 50, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, tt.w, tt.h))
+			out := centerSquareCrop(img)
+			b := out.Bounds()
+			if b.Dx() != b.Dy() {
+				t.Fatalf("centerSquareCrop(%dx%d) = %dx%d, want square", tt.w, tt.h, b.Dx(), b.Dy())
+			}
+			if b.Dx() != tt.minSide {
+				t.Errorf("centerSquareCrop(%dx%d) side = %d, want %d", tt.w, tt.h, b.Dx(), tt.minSide)
+			}
+		})
+	}
+}
+
+func TestSmartSquareCropProducesSquare(t *testing.T) {
+	withSquareMode(t, "smart", func() {
+		for _, tt := range []struct{ w, h int }{{120, 60}, {60, 120}, {80, 80}} {
+			img := image.NewRGBA(image.Rect(0, 0, tt.w, tt.h))
+			out := smartSquareCrop(img)
+			b := out.Bounds()
+			if b.Dx() != b.Dy() {
+				t.Errorf("smartSquareCrop(%dx%d) = %dx%d, want square", tt.w, tt.h, b.Dx(), b.Dy())
+			}
+			wantSide := min(tt.w, tt.h)
+			if b.Dx() != wantSide {
+				t.Errorf("smartSquareCrop(%dx%d) side = %d, want %d", tt.w, tt.h, b.Dx(), wantSide)
+			}
+		}
+	})
+}
+
+func TestBestWindowOffset(t *testing.T) {
+	tests := []struct {
+		name      string
+		hist      []float64
+		windowLen int
+		want      int
+	}{
+		{"energy concentrated at start", []float64{10, 10, 0, 0, 0}, 2, 0},
+		{"energy concentrated at end", []float64{0, 0, 0, 10, 10}, 2, 3},
+		{"energy in the middle", []float64{0, 5, 10, 5, 0}, 3, 1},
+		{"window covers whole histogram returns 0", []float64{1, 2, 3}, 3, 0},
+		{"window longer than histogram returns 0", []float64{1, 2, 3}, 5, 0},
+		{"zero-length window returns 0", []float64{1, 2, 3}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bestWindowOffset(tt.hist, tt.windowLen); got != tt.want {
+				t.Errorf("bestWindowOffset(%v, %d) = %d, want %d", tt.hist, tt.windowLen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSobelEnergyHistogramsFindsEdge(t *testing.T) {
+	// Left half black, right half white: a single vertical edge down the middle.
+	img := image.NewGray(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			c := color.Gray{Y: 0}
+			if x >= 10 {
+				c = color.Gray{Y: 255}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+
+	_, cols := sobelEnergyHistograms(img)
+
+	maxCol, maxEnergy := -1, -1.0
+	for i, e := range cols {
+		if e > maxEnergy {
+			maxEnergy = e
+			maxCol = i
+		}
+	}
+	if maxCol < 8 || maxCol > 11 {
+		t.Errorf("expected peak edge energy near the column boundary (x=9/10), got column %d", maxCol)
+	}
+}